@@ -28,6 +28,7 @@ import (
 
 	"github.com/milvus-io/milvus-proto/go-api/v2/commonpb"
 	"github.com/milvus-io/milvus/internal/proto/datapb"
+	"github.com/milvus-io/milvus/pkg/kv"
 	"github.com/milvus-io/milvus/pkg/log"
 	"github.com/milvus-io/milvus/pkg/metrics"
 	"github.com/milvus-io/milvus/pkg/util/tsoutil"
@@ -35,7 +36,6 @@ import (
 )
 
 // TODO this num should be determined by resources of datanode, for now, we set to a fixed value for simple
-// TODO we should split compaction into different priorities, small compaction helps to merge segment, large compaction helps to handle delta and expiration of large segments
 const (
 	tsTimeout = uint64(1)
 )
@@ -43,8 +43,10 @@ const (
 type compactionPlanContext interface {
 	start()
 	stop()
-	// execCompactionPlan start to execute plan and return immediately
-	execCompactionPlan(signal *compactionSignal, plan *datapb.CompactionPlan) error
+	// execCompactionPlan start to execute plan and return immediately.
+	// priority is decided by the caller (small-merge vs large-delta/expiration)
+	// and determines the plan's share of its channel's scheduler quota.
+	execCompactionPlan(signal *compactionSignal, plan *datapb.CompactionPlan, priority CompactionPriority) error
 	// getCompaction return compaction task. If planId does not exist, return nil.
 	getCompaction(planID int64) *compactionTask
 	// updateCompaction set the compaction state to timeout or completed
@@ -53,6 +55,11 @@ type compactionPlanContext interface {
 	isFull() bool
 	// get compaction tasks by signal id
 	getCompactionTasksBySignalID(signalID int64) []*compactionTask
+	// getFilteredDuplicatePlans returns the planID -> superseding planID map
+	// the DeduplicateFilter produced on its last run. There is no debug RPC
+	// surfacing this yet; today it is only logged once per check interval in
+	// start(). A future RPC handler can call this directly once one exists.
+	getFilteredDuplicatePlans() map[int64]int64
 }
 
 type compactionTaskState int8
@@ -68,6 +75,7 @@ const (
 var (
 	errChannelNotWatched = errors.New("channel is not watched")
 	errChannelInBuffer   = errors.New("channel is in buffer")
+	errPlanFiltered      = errors.New("compaction plan was dropped by a filter")
 )
 
 type compactionTask struct {
@@ -75,6 +83,7 @@ type compactionTask struct {
 	plan        *datapb.CompactionPlan
 	state       compactionTaskState
 	dataNodeID  int64
+	priority    CompactionPriority
 	result      *datapb.CompactionResult
 }
 
@@ -84,6 +93,7 @@ func (t *compactionTask) shadowClone(opts ...compactionTaskOpt) *compactionTask
 		plan:        t.plan,
 		state:       t.state,
 		dataNodeID:  t.dataNodeID,
+		priority:    t.priority,
 	}
 	for _, opt := range opts {
 		opt(task)
@@ -104,24 +114,65 @@ type compactionPlanHandler struct {
 	quit             chan struct{}
 	wg               sync.WaitGroup
 	flushCh          chan UniqueID
-	parallelCh       map[int64]chan struct{}
+	filters          *compactionPlanFilterChain
+	scheduler        *compactionScheduler
+	taskMeta         *compactionTaskMeta
 }
 
 func newCompactionPlanHandler(sessions SessionManager, cm *ChannelManager, meta *meta,
-	allocator allocator, flush chan UniqueID,
+	allocator allocator, flush chan UniqueID, metaKV kv.MetaKv,
 ) *compactionPlanHandler {
 	return &compactionPlanHandler{
-		plans:      make(map[int64]*compactionTask),
-		chManager:  cm,
-		meta:       meta,
-		sessions:   sessions,
-		allocator:  allocator,
-		flushCh:    flush,
-		parallelCh: make(map[int64]chan struct{}),
+		plans:     make(map[int64]*compactionTask),
+		chManager: cm,
+		meta:      meta,
+		sessions:  sessions,
+		allocator: allocator,
+		flushCh:   flush,
+		filters: newCompactionPlanFilterChain(
+			NewDeduplicateFilter(calculateParallel()),
+			&SegmentCompactingFilter{},
+			&ChannelShardFilter{chManager: cm},
+		),
+		scheduler: newCompactionScheduler(),
+		taskMeta:  newCompactionTaskMeta(metaKV),
 	}
 }
 
+// RegisterFilter appends a custom CompactionPlanFilter to the admission
+// chain, letting operators inject their own policies without recompiling.
+func (c *compactionPlanHandler) RegisterFilter(filter CompactionPlanFilter) {
+	c.filters.RegisterFilter(filter)
+}
+
+// pendingPlansLocked returns the plans of tasks that are still pending or
+// executing, i.e. the pool a new candidate should be checked for overlap
+// against. Must be called with c.mu held.
+func (c *compactionPlanHandler) pendingPlansLocked() map[int64]*datapb.CompactionPlan {
+	plans := make(map[int64]*datapb.CompactionPlan, len(c.plans))
+	for planID, task := range c.plans {
+		if task.state == pipelining || task.state == executing {
+			plans[planID] = task.plan
+		}
+	}
+	return plans
+}
+
+// getFilteredDuplicatePlans exposes the dedup filter's decisions. It is
+// logged once per check-interval tick in start(); nothing currently calls it
+// outside that, since this fragment has no debug RPC to wire it into.
+func (c *compactionPlanHandler) getFilteredDuplicatePlans() map[int64]int64 {
+	for _, filter := range c.filters.filters {
+		if dedup, ok := filter.(*DeduplicateFilter); ok {
+			return dedup.DuplicateIDs()
+		}
+	}
+	return nil
+}
+
 func (c *compactionPlanHandler) start() {
+	c.loadCompactionTasks()
+
 	interval := Params.DataCoordCfg.CompactionCheckIntervalInSeconds.GetAsDuration(time.Second)
 	c.quit = make(chan struct{})
 	c.wg.Add(2)
@@ -142,6 +193,9 @@ func (c *compactionPlanHandler) start() {
 					continue
 				}
 				_ = c.updateCompaction(ts)
+				if duplicates := c.getFilteredDuplicatePlans(); len(duplicates) > 0 {
+					log.Info("compaction plans dropped as duplicates", zap.Any("supersededBy", duplicates))
+				}
 			}
 		}
 	}()
@@ -162,6 +216,73 @@ func (c *compactionPlanHandler) start() {
 	}()
 }
 
+// loadCompactionTasks restores in-flight compaction tasks persisted to the
+// meta KV by an earlier process, so a datacoord restart doesn't forget them,
+// leak the isCompacting bit on their segments, or leave datanodes running
+// compactions that updateCompaction would otherwise label unknown.
+//
+// A reloaded executing task already made it through the scheduler in the
+// prior process, so its node/collection/channel slot is reserved directly.
+// A reloaded pipelining task was persisted before acquireQueue ran and
+// possibly again after it (when its start time was set), so whether it got
+// through the scheduler is unknown; its dispatch is simply restarted from
+// scratch; the DataNode Compaction RPC is idempotent per PlanID, so
+// re-sending it if the first attempt actually landed is harmless.
+func (c *compactionPlanHandler) loadCompactionTasks() {
+	tasks, err := c.taskMeta.List()
+	if err != nil {
+		log.Warn("failed to reload persisted compaction tasks", zap.Error(err))
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var resumed int
+	for _, task := range tasks {
+		log := log.With(zap.Int64("planID", task.plan.GetPlanID()), zap.Int64("nodeID", task.dataNodeID))
+		c.plans[task.plan.GetPlanID()] = task
+		if task.state != pipelining && task.state != executing {
+			continue
+		}
+
+		c.executingTaskNum++
+		c.setSegmentsCompacting(task.plan, true)
+
+		if !c.chManager.Match(task.dataNodeID, task.plan.GetChannel()) {
+			log.Info("reloaded compaction task's datanode no longer owns its channel, re-issuing SyncSegments")
+			nodeID, err := c.chManager.FindWatcher(task.plan.GetChannel())
+			if err != nil {
+				log.Warn("failed to find new watcher for reloaded compaction task", zap.Error(err))
+				continue
+			}
+			task.dataNodeID = nodeID
+			req := &datapb.SyncSegmentsRequest{PlanID: task.plan.GetPlanID()}
+			if err := c.sessions.SyncSegments(nodeID, req); err != nil {
+				log.Warn("failed to sync segments for reloaded compaction task", zap.Error(err))
+			}
+		}
+
+		if task.state == executing {
+			c.scheduler.reserve(task.dataNodeID, task.triggerInfo.collectionID, task.plan.GetChannel())
+			continue
+		}
+
+		log.Info("resuming reloaded pipelining compaction task")
+		c.dispatchTask(task)
+		resumed++
+	}
+	log.Info("reloaded persisted compaction tasks", zap.Int("numTasks", len(tasks)), zap.Int("resumed", resumed))
+}
+
+// persistTask saves task's current state to the meta KV under the
+// compaction-task/ prefix so it survives a coordinator restart. Must be
+// called with c.mu held.
+func (c *compactionPlanHandler) persistTask(task *compactionTask) {
+	if err := c.taskMeta.Save(task); err != nil {
+		log.Warn("failed to persist compaction task", zap.Int64("planID", task.plan.GetPlanID()), zap.Error(err))
+	}
+}
+
 func (c *compactionPlanHandler) Clean() {
 	current := tsoutil.GetCurrentTime()
 	c.mu.Lock()
@@ -174,6 +295,9 @@ func (c *compactionPlanHandler) Clean() {
 		// after timeout + 1h, the plan will be cleaned
 		if c.isTimeout(current, task.plan.GetStartTime(), task.plan.GetTimeoutInSeconds()+60*60) {
 			delete(c.plans, id)
+			if err := c.taskMeta.Drop(id); err != nil {
+				log.Warn("failed to GC persisted compaction task", zap.Int64("planID", id), zap.Error(err))
+			}
 		}
 	}
 }
@@ -199,15 +323,31 @@ func (c *compactionPlanHandler) updateTask(planID int64, opts ...compactionTaskO
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if plan, ok := c.plans[planID]; ok {
-		c.plans[planID] = plan.shadowClone(opts...)
+		task := plan.shadowClone(opts...)
+		c.plans[planID] = task
+		c.persistTask(task)
 	}
 }
 
-// execCompactionPlan start to execute plan and return immediately
-func (c *compactionPlanHandler) execCompactionPlan(signal *compactionSignal, plan *datapb.CompactionPlan) error {
+// execCompactionPlan runs plan through the registered CompactionPlanFilter
+// chain and, if it survives, starts executing it. It returns immediately;
+// execution continues in the background.
+func (c *compactionPlanHandler) execCompactionPlan(signal *compactionSignal, plan *datapb.CompactionPlan, priority CompactionPriority) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	// Compare plan against every other plan still pending or in flight, not
+	// just against itself, so filters like DeduplicateFilter actually have
+	// something to overlap-check; only plan's own admission is decided here,
+	// dropping an already-admitted entry from candidates has no effect on it.
+	candidates := c.pendingPlansLocked()
+	candidates[plan.GetPlanID()] = plan
+	c.filters.Apply(context.TODO(), candidates, c.meta)
+	if _, ok := candidates[plan.GetPlanID()]; !ok {
+		log.Info("compaction plan was filtered out before admission", zap.Int64("planID", plan.GetPlanID()))
+		return errPlanFiltered
+	}
+
 	nodeID, err := c.chManager.FindWatcher(plan.GetChannel())
 	if err != nil {
 		log.Error("failed to find watcher", zap.Int64("planID", plan.GetPlanID()), zap.Error(err))
@@ -222,13 +362,28 @@ func (c *compactionPlanHandler) execCompactionPlan(signal *compactionSignal, pla
 		plan:        plan,
 		state:       pipelining,
 		dataNodeID:  nodeID,
+		priority:    priority,
 	}
 	c.plans[plan.PlanID] = task
 	c.executingTaskNum++
+	c.persistTask(task)
+	c.dispatchTask(task)
+	return nil
+}
+
+// dispatchTask runs task through acquireQueue, timestamp allocation and the
+// DataNode Compaction RPC in the background and returns immediately. It is
+// used both for a freshly admitted plan and to resume a pipelining task
+// found by loadCompactionTasks on restart, since in both cases the task has
+// been persisted but its Compaction RPC has not been confirmed sent.
+func (c *compactionPlanHandler) dispatchTask(task *compactionTask) {
+	plan := task.plan
+	nodeID := task.dataNodeID
+	log := log.With(zap.Int64("planID", plan.GetPlanID()), zap.Int64("nodeID", nodeID))
 
 	go func() {
 		log.Info("acquire queue")
-		c.acquireQueue(nodeID)
+		c.acquireQueue(nodeID, task.triggerInfo.collectionID, plan.GetChannel(), task.priority)
 
 		ts, err := c.allocator.allocTimestamp(context.TODO())
 		if err != nil {
@@ -248,7 +403,6 @@ func (c *compactionPlanHandler) execCompactionPlan(signal *compactionSignal, pla
 		}
 		log.Info("start compaction")
 	}()
-	return nil
 }
 
 func (c *compactionPlanHandler) setSegmentsCompacting(plan *datapb.CompactionPlan, compacting bool) {
@@ -280,6 +434,7 @@ func (c *compactionPlanHandler) completeCompaction(result *datapb.CompactionResu
 	}
 	metrics.DataCoordCompactedSegmentSize.WithLabelValues().Observe(float64(getCompactedSegmentSize(result)))
 	c.plans[planID] = c.plans[planID].shadowClone(setState(completed), setResult(result), cleanLogPath())
+	c.persistTask(c.plans[planID])
 	c.executingTaskNum--
 	if c.plans[planID].plan.GetType() == datapb.CompactionType_MergeCompaction ||
 		c.plans[planID].plan.GetType() == datapb.CompactionType_MixCompaction {
@@ -287,8 +442,8 @@ func (c *compactionPlanHandler) completeCompaction(result *datapb.CompactionResu
 	}
 	// TODO: when to clean task list
 
-	nodeID := c.plans[planID].dataNodeID
-	c.releaseQueue(nodeID)
+	task := c.plans[planID]
+	c.releaseQueue(task.dataNodeID, task.triggerInfo.collectionID, task.plan.GetChannel())
 	return nil
 }
 
@@ -369,9 +524,10 @@ func (c *compactionPlanHandler) updateCompaction(ts Timestamp) error {
 						continue
 					}
 					c.plans[planID] = c.plans[planID].shadowClone(setState(failed))
+					c.persistTask(c.plans[planID])
 					c.setSegmentsCompacting(task.plan, false)
 					c.executingTaskNum--
-					c.releaseQueue(task.dataNodeID)
+					c.releaseQueue(task.dataNodeID, task.triggerInfo.collectionID, task.plan.GetChannel())
 				}
 
 				if err := c.completeCompaction(planResult.GetResult()); err != nil {
@@ -385,14 +541,16 @@ func (c *compactionPlanHandler) updateCompaction(ts Timestamp) error {
 						zap.Uint64("now", ts),
 					)
 					c.plans[planID] = c.plans[planID].shadowClone(setState(timeout))
+					c.persistTask(c.plans[planID])
 				}
 			}
 		} else {
 			log.Info("compaction failed")
 			c.plans[planID] = c.plans[planID].shadowClone(setState(failed))
+			c.persistTask(c.plans[planID])
 			c.setSegmentsCompacting(task.plan, false)
 			c.executingTaskNum--
-			c.releaseQueue(task.dataNodeID)
+			c.releaseQueue(task.dataNodeID, task.triggerInfo.collectionID, task.plan.GetChannel())
 		}
 	}
 
@@ -416,9 +574,10 @@ func (c *compactionPlanHandler) updateCompaction(ts Timestamp) error {
 			// compaction task in DC but not found in DN means the compactino plan has failed
 			log.Info("compaction failed for timeout")
 			c.plans[planID] = c.plans[planID].shadowClone(setState(failed))
+			c.persistTask(c.plans[planID])
 			c.setSegmentsCompacting(task.plan, false)
 			c.executingTaskNum--
-			c.releaseQueue(task.dataNodeID)
+			c.releaseQueue(task.dataNodeID, task.triggerInfo.collectionID, task.plan.GetChannel())
 		}
 	}
 
@@ -452,27 +611,15 @@ func (c *compactionPlanHandler) isTimeout(now Timestamp, start Timestamp, timeou
 	return int32(ts.Sub(startTime).Seconds()) >= timeout
 }
 
-func (c *compactionPlanHandler) acquireQueue(nodeID int64) {
-	c.mu.Lock()
-	_, ok := c.parallelCh[nodeID]
-	if !ok {
-		c.parallelCh[nodeID] = make(chan struct{}, calculateParallel())
-	}
-	c.mu.Unlock()
-
-	c.mu.RLock()
-	ch := c.parallelCh[nodeID]
-	c.mu.RUnlock()
-	ch <- struct{}{}
+// acquireQueue blocks until a slot is free for nodeID at every level of the
+// scheduler's dataNode/collection/channel hierarchy.
+func (c *compactionPlanHandler) acquireQueue(nodeID, collectionID int64, channel string, priority CompactionPriority) {
+	c.scheduler.acquire(nodeID, collectionID, channel, priority)
 }
 
-func (c *compactionPlanHandler) releaseQueue(nodeID int64) {
-	log.Info("try to release queue", zap.Int64("nodeID", nodeID))
-	ch, ok := c.parallelCh[nodeID]
-	if !ok {
-		return
-	}
-	<-ch
+func (c *compactionPlanHandler) releaseQueue(nodeID, collectionID int64, channel string) {
+	log.Info("try to release queue", zap.Int64("nodeID", nodeID), zap.Int64("collectionID", collectionID), zap.String("channel", channel))
+	c.scheduler.release(nodeID, collectionID, channel)
 }
 
 // isFull return true if the task pool is full
@@ -480,7 +627,10 @@ func (c *compactionPlanHandler) isFull() bool {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	return c.executingTaskNum >= Params.DataCoordCfg.CompactionMaxParallelTasks.GetAsInt()
+	if c.executingTaskNum >= Params.DataCoordCfg.CompactionMaxParallelTasks.GetAsInt() {
+		return true
+	}
+	return c.scheduler.isFull()
 }
 
 func (c *compactionPlanHandler) getTasksByState(state compactionTaskState) []*compactionTask {
@@ -0,0 +1,267 @@
+// Licensed to the LF AI & Data foundation under one
+// or more contributor license agreements. See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership. The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License. You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datacoord
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/milvus-io/milvus/internal/proto/datapb"
+	"github.com/milvus-io/milvus/pkg/log"
+	"github.com/milvus-io/milvus/pkg/metrics"
+)
+
+// CompactionPlanFilter decides which candidate compaction plans are allowed
+// to proceed to execution. Filters are composed into an ordered chain, each
+// seeing only the plans that survived the previous one, the same way a
+// Thanos BaseFetcher composes its metadata filters. A filter drops a plan by
+// deleting it from the supplied map; it must record the reason it dropped a
+// plan via metrics.DataCoordCompactionPlansFiltered.
+type CompactionPlanFilter interface {
+	// Name identifies the filter in logs and as the metric label.
+	Name() string
+	// Filter removes disallowed entries from plans in place.
+	Filter(ctx context.Context, plans map[int64]*datapb.CompactionPlan, meta *meta)
+}
+
+// compactionPlanFilterChain runs a sequence of CompactionPlanFilters over a
+// candidate plan set before any of them is admitted into execution.
+type compactionPlanFilterChain struct {
+	mu      sync.RWMutex
+	filters []CompactionPlanFilter
+}
+
+func newCompactionPlanFilterChain(filters ...CompactionPlanFilter) *compactionPlanFilterChain {
+	return &compactionPlanFilterChain{filters: filters}
+}
+
+// RegisterFilter appends a custom filter to the end of the chain, letting
+// operators inject their own admission policies.
+func (c *compactionPlanFilterChain) RegisterFilter(filter CompactionPlanFilter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.filters = append(c.filters, filter)
+}
+
+// Apply runs every registered filter in order, each operating on whatever
+// survived the previous one.
+func (c *compactionPlanFilterChain) Apply(ctx context.Context, plans map[int64]*datapb.CompactionPlan, meta *meta) {
+	c.mu.RLock()
+	filters := make([]CompactionPlanFilter, len(c.filters))
+	copy(filters, c.filters)
+	c.mu.RUnlock()
+
+	for _, filter := range filters {
+		before := len(plans)
+		filter.Filter(ctx, plans, meta)
+		if dropped := before - len(plans); dropped > 0 {
+			log.Info("compaction plan filter dropped candidates",
+				zap.String("filter", filter.Name()), zap.Int("dropped", dropped), zap.Int("remaining", len(plans)))
+		}
+	}
+}
+
+// SegmentCompactingFilter drops plans that touch a segment already marked
+// isCompacting in meta, e.g. because an earlier plan claimed it first.
+type SegmentCompactingFilter struct{}
+
+func (f *SegmentCompactingFilter) Name() string {
+	return "SegmentCompactingFilter"
+}
+
+func (f *SegmentCompactingFilter) Filter(ctx context.Context, plans map[int64]*datapb.CompactionPlan, meta *meta) {
+	for planID, plan := range plans {
+		for _, segmentBinlogs := range plan.GetSegmentBinlogs() {
+			segment := meta.GetSegment(segmentBinlogs.GetSegmentID())
+			if segment != nil && segment.isCompacting {
+				log.Info("filter dropped compaction plan: segment already compacting",
+					zap.Int64("planID", planID), zap.Int64("segmentID", segmentBinlogs.GetSegmentID()))
+				metrics.DataCoordCompactionPlansFiltered.WithLabelValues(f.Name(), "segment_compacting").Inc()
+				delete(plans, planID)
+				break
+			}
+		}
+	}
+}
+
+// ChannelShardFilter drops plans whose channel isn't owned by a watcher the
+// handler can currently reach. This used to be an inline check at the top of
+// execCompactionPlan.
+type ChannelShardFilter struct {
+	chManager *ChannelManager
+}
+
+func (f *ChannelShardFilter) Name() string {
+	return "ChannelShardFilter"
+}
+
+func (f *ChannelShardFilter) Filter(ctx context.Context, plans map[int64]*datapb.CompactionPlan, meta *meta) {
+	for planID, plan := range plans {
+		if _, err := f.chManager.FindWatcher(plan.GetChannel()); err != nil {
+			log.Warn("filter dropped compaction plan: no reachable watcher for channel",
+				zap.Int64("planID", planID), zap.String("channel", plan.GetChannel()), zap.Error(err))
+			metrics.DataCoordCompactionPlansFiltered.WithLabelValues(f.Name(), "channel_not_watched").Inc()
+			delete(plans, planID)
+		}
+	}
+}
+
+// DeduplicateFilter drops a pending plan when another pending plan's segment
+// set is a superset of its SegmentBinlogs, i.e. the smaller plan's work is
+// already covered by the bigger one. Ties are broken by preferring the plan
+// covering more rows, then the newer (larger) PlanID. Since the candidate set
+// can be large, every pair is checked concurrently by a bounded worker pool.
+type DeduplicateFilter struct {
+	workerNum int
+
+	mu           sync.Mutex
+	duplicateIDs map[int64]int64 // dropped planID -> the plan that superseded it
+}
+
+func NewDeduplicateFilter(workerNum int) *DeduplicateFilter {
+	if workerNum <= 0 {
+		workerNum = 1
+	}
+	return &DeduplicateFilter{
+		workerNum:    workerNum,
+		duplicateIDs: make(map[int64]int64),
+	}
+}
+
+func (f *DeduplicateFilter) Name() string {
+	return "DeduplicateFilter"
+}
+
+// DuplicateIDs returns the planID -> superseding planID mapping computed
+// during the most recent Filter call, via
+// compactionPlanHandler.getFilteredDuplicatePlans. It is logged periodically
+// today; nothing exposes it over RPC yet.
+func (f *DeduplicateFilter) DuplicateIDs() map[int64]int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make(map[int64]int64, len(f.duplicateIDs))
+	for k, v := range f.duplicateIDs {
+		out[k] = v
+	}
+	return out
+}
+
+type dedupCandidate struct {
+	planID   int64
+	segments map[int64]struct{}
+	rows     int64
+}
+
+func (f *DeduplicateFilter) Filter(ctx context.Context, plans map[int64]*datapb.CompactionPlan, meta *meta) {
+	candidates := make([]dedupCandidate, 0, len(plans))
+	for planID, plan := range plans {
+		segments := make(map[int64]struct{}, len(plan.GetSegmentBinlogs()))
+		var rows int64
+		for _, segmentBinlogs := range plan.GetSegmentBinlogs() {
+			segments[segmentBinlogs.GetSegmentID()] = struct{}{}
+			if segment := meta.GetSegment(segmentBinlogs.GetSegmentID()); segment != nil {
+				rows += segment.GetNumOfRows()
+			}
+		}
+		candidates = append(candidates, dedupCandidate{planID: planID, segments: segments, rows: rows})
+	}
+
+	type pair struct{ i, j int }
+	pairs := make(chan pair, len(candidates))
+	go func() {
+		for i := range candidates {
+			for j := i + 1; j < len(candidates); j++ {
+				pairs <- pair{i, j}
+			}
+		}
+		close(pairs)
+	}()
+
+	dropped := make(map[int64]int64)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < f.workerNum; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range pairs {
+				loserID, winnerID, ok := compareOverlap(candidates[p.i], candidates[p.j])
+				if !ok {
+					continue
+				}
+				mu.Lock()
+				dropped[loserID] = winnerID
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	f.mu.Lock()
+	f.duplicateIDs = dropped
+	f.mu.Unlock()
+
+	for planID, supersededBy := range dropped {
+		log.Info("filter dropped duplicate compaction plan",
+			zap.Int64("planID", planID), zap.Int64("supersededBy", supersededBy))
+		metrics.DataCoordCompactionPlansFiltered.WithLabelValues(f.Name(), "duplicate").Inc()
+		delete(plans, planID)
+	}
+}
+
+// compareOverlap reports which of a and b should be dropped when one's
+// segment set is a subset of the other's. ok is false when neither set is a
+// subset of the other, i.e. the two plans don't overlap this way.
+func compareOverlap(a, b dedupCandidate) (loserID, winnerID int64, ok bool) {
+	aSubsetOfB := isSubset(a.segments, b.segments)
+	bSubsetOfA := isSubset(b.segments, a.segments)
+	switch {
+	case aSubsetOfB && !bSubsetOfA:
+		return a.planID, b.planID, true
+	case bSubsetOfA && !aSubsetOfB:
+		return b.planID, a.planID, true
+	case aSubsetOfB && bSubsetOfA:
+		// Identical segment sets: prefer the plan covering more rows, then
+		// the newer PlanID.
+		if a.rows != b.rows {
+			if a.rows > b.rows {
+				return b.planID, a.planID, true
+			}
+			return a.planID, b.planID, true
+		}
+		if a.planID > b.planID {
+			return b.planID, a.planID, true
+		}
+		return a.planID, b.planID, true
+	default:
+		return 0, 0, false
+	}
+}
+
+func isSubset(small, big map[int64]struct{}) bool {
+	if len(small) > len(big) {
+		return false
+	}
+	for id := range small {
+		if _, ok := big[id]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+// Licensed to the LF AI & Data foundation under one
+// or more contributor license agreements. See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership. The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License. You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datacoord
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func segSet(ids ...int64) map[int64]struct{} {
+	set := make(map[int64]struct{}, len(ids))
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+	return set
+}
+
+func TestIsSubset(t *testing.T) {
+	assert.True(t, isSubset(segSet(), segSet(1, 2)))
+	assert.True(t, isSubset(segSet(1), segSet(1, 2)))
+	assert.True(t, isSubset(segSet(1, 2), segSet(1, 2)))
+	assert.False(t, isSubset(segSet(1, 3), segSet(1, 2)))
+	assert.False(t, isSubset(segSet(1, 2), segSet(1)))
+}
+
+func TestCompareOverlap(t *testing.T) {
+	t.Run("disjoint plans don't overlap", func(t *testing.T) {
+		a := dedupCandidate{planID: 1, segments: segSet(1, 2)}
+		b := dedupCandidate{planID: 2, segments: segSet(3, 4)}
+		_, _, ok := compareOverlap(a, b)
+		assert.False(t, ok)
+	})
+
+	t.Run("strict subset loses to its superset", func(t *testing.T) {
+		small := dedupCandidate{planID: 1, segments: segSet(1)}
+		big := dedupCandidate{planID: 2, segments: segSet(1, 2)}
+
+		loser, winner, ok := compareOverlap(small, big)
+		assert.True(t, ok)
+		assert.Equal(t, small.planID, loser)
+		assert.Equal(t, big.planID, winner)
+
+		// symmetric regardless of argument order
+		loser, winner, ok = compareOverlap(big, small)
+		assert.True(t, ok)
+		assert.Equal(t, small.planID, loser)
+		assert.Equal(t, big.planID, winner)
+	})
+
+	t.Run("identical segment sets prefer more rows", func(t *testing.T) {
+		fewerRows := dedupCandidate{planID: 1, segments: segSet(1, 2), rows: 100}
+		moreRows := dedupCandidate{planID: 2, segments: segSet(1, 2), rows: 200}
+
+		loser, winner, ok := compareOverlap(fewerRows, moreRows)
+		assert.True(t, ok)
+		assert.Equal(t, fewerRows.planID, loser)
+		assert.Equal(t, moreRows.planID, winner)
+	})
+
+	t.Run("identical segment sets and rows prefer newer planID", func(t *testing.T) {
+		older := dedupCandidate{planID: 1, segments: segSet(1, 2), rows: 100}
+		newer := dedupCandidate{planID: 2, segments: segSet(1, 2), rows: 100}
+
+		loser, winner, ok := compareOverlap(older, newer)
+		assert.True(t, ok)
+		assert.Equal(t, older.planID, loser)
+		assert.Equal(t, newer.planID, winner)
+
+		// order of arguments shouldn't change the tie-break outcome
+		loser, winner, ok = compareOverlap(newer, older)
+		assert.True(t, ok)
+		assert.Equal(t, older.planID, loser)
+		assert.Equal(t, newer.planID, winner)
+	})
+}
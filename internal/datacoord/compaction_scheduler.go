@@ -0,0 +1,284 @@
+// Licensed to the LF AI & Data foundation under one
+// or more contributor license agreements. See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership. The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License. You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datacoord
+
+import (
+	"math"
+	"sync"
+
+	"github.com/milvus-io/milvus/pkg/metrics"
+)
+
+// CompactionPriority distinguishes small-merge compactions, which keep
+// segment counts down, from large delta/expiration compactions, which
+// reclaim deleted or expired rows from big segments. The scheduler gives
+// each its own share of a channel's quota so one doesn't starve the other.
+//
+// compactionSignal doesn't carry this split - it's defined by the trigger
+// path, outside this change - so it's threaded through as an explicit
+// argument to execCompactionPlan instead: callers (the trigger path) decide
+// whether a plan is a small merge or a large delta/expiration compaction and
+// pass that decision in, rather than the scheduler guessing from plan type.
+type CompactionPriority int8
+
+const (
+	PrioritySmallMerge CompactionPriority = iota
+	PriorityLargeDelta
+)
+
+// weight is the deficit round-robin quantum a priority is worth: small
+// merges are scheduled more eagerly since they're cheap and keep segment
+// counts in check, large delta/expiration compactions touch far more data
+// per task and get a smaller share.
+func (p CompactionPriority) weight() int {
+	switch p {
+	case PrioritySmallMerge:
+		return 3
+	default:
+		return 1
+	}
+}
+
+// schedWaiter is a single blocked acquireQueue call.
+type schedWaiter struct {
+	nodeID       int64
+	collectionID int64
+	priority     CompactionPriority
+	grant        chan struct{}
+}
+
+// priorityOrder lists priorities from most to least eager. dispatchChannel
+// visits them in this order every round, so a channel with pending
+// small-merge work is always considered ahead of its large-delta work,
+// instead of whichever arrived first.
+var priorityOrder = []CompactionPriority{PrioritySmallMerge, PriorityLargeDelta}
+
+// channelQueue holds one waiter sub-queue and deficit counter per priority
+// for a single channel.
+type channelQueue struct {
+	waiters map[CompactionPriority][]*schedWaiter
+	deficit map[CompactionPriority]int
+}
+
+func newChannelQueue() *channelQueue {
+	return &channelQueue{
+		waiters: make(map[CompactionPriority][]*schedWaiter),
+		deficit: make(map[CompactionPriority]int),
+	}
+}
+
+func (q *channelQueue) depth() int {
+	total := 0
+	for _, waiters := range q.waiters {
+		total += len(waiters)
+	}
+	return total
+}
+
+// compactionScheduler enforces a three-level admission hierarchy -
+// dataNode, collection, then channel - and admits channels contending for
+// that shared capacity in deficit round-robin order, keyed by
+// plan.GetChannel(), so a single hot channel cannot starve the others.
+// Within a channel, waiters are further split by CompactionPriority, each
+// with its own deficit, so a channel backlog of large-delta compactions
+// can't starve small merges queued behind it.
+type compactionScheduler struct {
+	mu sync.Mutex
+
+	nodeLimit       int
+	collectionLimit int
+	channelLimit    int
+
+	nodeUsed       map[int64]int
+	collectionUsed map[int64]int
+	channelUsed    map[string]int
+
+	queues map[string]*channelQueue
+	order  []string // channels with pending waiters, visited round-robin
+}
+
+func newCompactionScheduler() *compactionScheduler {
+	return &compactionScheduler{
+		nodeLimit:       clampLimit(calculateParallel()),
+		collectionLimit: clampLimit(Params.DataCoordCfg.CompactionMaxParallelPerCollection.GetAsInt()),
+		channelLimit:    clampLimit(Params.DataCoordCfg.CompactionMaxParallelPerChannel.GetAsInt()),
+		nodeUsed:        make(map[int64]int),
+		collectionUsed:  make(map[int64]int),
+		channelUsed:     make(map[string]int),
+		queues:          make(map[string]*channelQueue),
+	}
+}
+
+// clampLimit treats a non-positive config value as "unlimited" rather than
+// letting it collapse to a limit of 0, which would make hasCapacity always
+// false and every acquire() block forever.
+func clampLimit(limit int) int {
+	if limit <= 0 {
+		return math.MaxInt
+	}
+	return limit
+}
+
+// acquire blocks until a slot is free at every level for (nodeID,
+// collectionID, channel).
+func (s *compactionScheduler) acquire(nodeID, collectionID int64, channel string, priority CompactionPriority) {
+	s.mu.Lock()
+	w := &schedWaiter{nodeID: nodeID, collectionID: collectionID, priority: priority, grant: make(chan struct{})}
+	s.enqueue(channel, w)
+	s.dispatch()
+	s.mu.Unlock()
+
+	<-w.grant
+}
+
+// reserve directly marks a slot as already consumed at every level, without
+// queueing or blocking. It's for a task whose admission happened in a prior
+// process - reloaded from persisted state on restart - so its slot needs to
+// be accounted for again without going through acquire's queue.
+func (s *compactionScheduler) reserve(nodeID, collectionID int64, channel string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nodeUsed[nodeID]++
+	s.collectionUsed[collectionID]++
+	s.channelUsed[channel]++
+}
+
+// release returns a previously acquired slot and runs a fresh dispatch pass,
+// since capacity just freed up for waiting channels.
+func (s *compactionScheduler) release(nodeID, collectionID int64, channel string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.nodeUsed[nodeID] > 0 {
+		s.nodeUsed[nodeID]--
+	}
+	if s.collectionUsed[collectionID] > 0 {
+		s.collectionUsed[collectionID]--
+	}
+	if s.channelUsed[channel] > 0 {
+		s.channelUsed[channel]--
+	}
+	s.dispatch()
+}
+
+// isFull reports whether, at any one level, every dataNode, every collection
+// or every channel the scheduler has ever admitted work for is currently
+// saturated, used by compactionPlanHandler.isFull() in addition to the global
+// executingTaskNum cap. One saturated level is enough: acquire() only admits
+// a waiter when there is room at all three levels.
+func (s *compactionScheduler) isFull() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return allSaturated(s.nodeUsed, s.nodeLimit) ||
+		allSaturated(s.collectionUsed, s.collectionLimit) ||
+		allSaturated(s.channelUsed, s.channelLimit)
+}
+
+func allSaturated[K comparable](used map[K]int, limit int) bool {
+	if len(used) == 0 {
+		return false
+	}
+	for _, u := range used {
+		if u < limit {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *compactionScheduler) enqueue(channel string, w *schedWaiter) {
+	q, ok := s.queues[channel]
+	if !ok {
+		q = newChannelQueue()
+		s.queues[channel] = q
+		s.order = append(s.order, channel)
+	}
+	q.waiters[w.priority] = append(q.waiters[w.priority], w)
+	metrics.DataCoordCompactionQueueDepth.WithLabelValues(channel).Set(float64(q.depth()))
+}
+
+func (s *compactionScheduler) hasCapacity(nodeID, collectionID int64, channel string) bool {
+	return s.nodeUsed[nodeID] < s.nodeLimit &&
+		s.collectionUsed[collectionID] < s.collectionLimit &&
+		s.channelUsed[channel] < s.channelLimit
+}
+
+func (s *compactionScheduler) admit(channel string, priority CompactionPriority, w *schedWaiter) {
+	s.nodeUsed[w.nodeID]++
+	s.collectionUsed[w.collectionID]++
+	s.channelUsed[channel]++
+	q := s.queues[channel]
+	q.waiters[priority] = q.waiters[priority][1:]
+	close(w.grant)
+	metrics.DataCoordCompactionQueueDepth.WithLabelValues(channel).Set(float64(q.depth()))
+}
+
+// dispatch runs one deficit round-robin sweep over channels with pending
+// waiters. Must be called with s.mu held.
+func (s *compactionScheduler) dispatch() {
+	for _, channel := range s.order {
+		s.dispatchChannel(channel)
+	}
+	s.pruneOrder()
+	// Start the next sweep one channel later, so the channel that gets first
+	// claim on freed capacity rotates between sweeps.
+	if len(s.order) > 1 {
+		s.order = append(s.order[1:], s.order[0])
+	}
+}
+
+// dispatchChannel drains channel's per-priority sub-queues in priorityOrder:
+// the highest-priority non-empty sub-queue is always considered first, each
+// gets its own deficit topped up by its priority's weight, and is drained
+// while that deficit covers its head's cost and capacity allows. A
+// low-priority sub-queue still gets a quantum every round even when a
+// higher one is non-empty, so it keeps making progress once capacity frees
+// up - it just loses ties for the same slot.
+func (s *compactionScheduler) dispatchChannel(channel string) {
+	q := s.queues[channel]
+	for _, priority := range priorityOrder {
+		queue := q.waiters[priority]
+		if len(queue) == 0 {
+			continue
+		}
+		q.deficit[priority] += priority.weight()
+		for len(queue) > 0 {
+			head := queue[0]
+			cost := 1
+			if q.deficit[priority] < cost || !s.hasCapacity(head.nodeID, head.collectionID, channel) {
+				break
+			}
+			s.admit(channel, priority, head)
+			q.deficit[priority] -= cost
+			queue = q.waiters[priority]
+		}
+	}
+}
+
+func (s *compactionScheduler) pruneOrder() {
+	next := s.order[:0]
+	for _, channel := range s.order {
+		if s.queues[channel].depth() == 0 {
+			delete(s.queues, channel)
+			continue
+		}
+		next = append(next, channel)
+	}
+	s.order = next
+}
@@ -0,0 +1,130 @@
+// Licensed to the LF AI & Data foundation under one
+// or more contributor license agreements. See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership. The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License. You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datacoord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestScheduler builds a scheduler with explicit limits instead of going
+// through newCompactionScheduler, so tests don't depend on Params being
+// initialized.
+func newTestScheduler(nodeLimit, collectionLimit, channelLimit int) *compactionScheduler {
+	return &compactionScheduler{
+		nodeLimit:       clampLimit(nodeLimit),
+		collectionLimit: clampLimit(collectionLimit),
+		channelLimit:    clampLimit(channelLimit),
+		nodeUsed:        make(map[int64]int),
+		collectionUsed:  make(map[int64]int),
+		channelUsed:     make(map[string]int),
+		queues:          make(map[string]*channelQueue),
+	}
+}
+
+func TestSchedulerAcquireReleaseIsFull(t *testing.T) {
+	s := newTestScheduler(1, 10, 10)
+
+	done := make(chan struct{})
+	go func() {
+		s.acquire(1, 100, "ch1", PrioritySmallMerge)
+		close(done)
+	}()
+	requireClosed(t, done, "first acquire should not block")
+
+	assert.True(t, s.isFull(), "sole node slot should be taken")
+
+	blocked := make(chan struct{})
+	go func() {
+		s.acquire(1, 101, "ch1", PrioritySmallMerge)
+		close(blocked)
+	}()
+	requireNotClosed(t, blocked, "second acquire on a saturated node should block")
+
+	s.release(1, 100, "ch1")
+	requireClosed(t, blocked, "release should free the node slot for the waiter")
+	assert.True(t, s.isFull())
+
+	s.release(1, 101, "ch1")
+	assert.False(t, s.isFull())
+}
+
+func TestSchedulerReserveCountsAsUsed(t *testing.T) {
+	s := newTestScheduler(1, 10, 10)
+	s.reserve(1, 100, "ch1")
+	assert.True(t, s.isFull())
+
+	blocked := make(chan struct{})
+	go func() {
+		s.acquire(1, 101, "ch1", PrioritySmallMerge)
+		close(blocked)
+	}()
+	requireNotClosed(t, blocked, "acquire should block against a reserved slot")
+
+	s.release(1, 100, "ch1")
+	requireClosed(t, blocked, "releasing a reserved slot should admit the waiter")
+}
+
+func TestSchedulerPriorityOrdersAdmission(t *testing.T) {
+	s := newTestScheduler(1, 10, 10)
+	// Take the sole node slot so both waiters below queue up behind it.
+	s.acquire(1, 100, "ch1", PrioritySmallMerge)
+
+	largeDeltaGrant := make(chan struct{})
+	go func() {
+		s.acquire(1, 101, "ch1", PriorityLargeDelta)
+		close(largeDeltaGrant)
+	}()
+	requireNotClosed(t, largeDeltaGrant, "large-delta waiter should queue behind the busy node")
+
+	smallMergeGrant := make(chan struct{})
+	go func() {
+		s.acquire(1, 102, "ch1", PrioritySmallMerge)
+		close(smallMergeGrant)
+	}()
+	requireNotClosed(t, smallMergeGrant, "small-merge waiter should also queue behind the busy node")
+
+	// Free the single slot: both waiters are eligible, but small-merge is
+	// the higher priority sub-queue, so it must be admitted first.
+	s.release(1, 100, "ch1")
+	requireClosed(t, smallMergeGrant, "small-merge should be admitted ahead of large-delta")
+	requireNotClosed(t, largeDeltaGrant, "large-delta should still be waiting for the one free slot")
+
+	s.release(1, 102, "ch1")
+	requireClosed(t, largeDeltaGrant, "large-delta should be admitted once small-merge releases")
+}
+
+func requireClosed(t *testing.T, ch chan struct{}, msg string) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		require.Fail(t, "timed out waiting for channel to close: "+msg)
+	}
+}
+
+func requireNotClosed(t *testing.T, ch chan struct{}, msg string) {
+	t.Helper()
+	select {
+	case <-ch:
+		require.Fail(t, "channel closed unexpectedly: "+msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,142 @@
+// Licensed to the LF AI & Data foundation under one
+// or more contributor license agreements. See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership. The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License. You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datacoord
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"github.com/milvus-io/milvus/internal/proto/datapb"
+	"github.com/milvus-io/milvus/pkg/kv"
+	"github.com/milvus-io/milvus/pkg/log"
+)
+
+// compactionTaskPrefix is the meta KV prefix every persisted compactionTask
+// is stored under, keyed by its PlanID.
+const compactionTaskPrefix = "compaction-task"
+
+// persistedCompactionTask is the on-the-wire form of a compactionTask: just
+// enough to resume it after a coordinator restart - the plan itself, its
+// state, which dataNode owns it, when it started, and the id/collectionID of
+// the signal that triggered it.
+type persistedCompactionTask struct {
+	PlanID       int64  `json:"planID"`
+	PlanBytes    []byte `json:"plan"`
+	State        int8   `json:"state"`
+	DataNodeID   int64  `json:"dataNodeID"`
+	Priority     int8   `json:"priority"`
+	SignalID     int64  `json:"signalID"`
+	CollectionID int64  `json:"collectionID"`
+}
+
+func compactionTaskKey(planID int64) string {
+	return compactionTaskPrefix + "/" + strconv.FormatInt(planID, 10)
+}
+
+// compactionTaskMeta persists compactionTasks to the meta KV so an in-flight
+// compaction survives a datacoord restart instead of being silently
+// forgotten.
+type compactionTaskMeta struct {
+	kv kv.MetaKv
+}
+
+func newCompactionTaskMeta(kv kv.MetaKv) *compactionTaskMeta {
+	return &compactionTaskMeta{kv: kv}
+}
+
+// Save persists task's current state, overwriting whatever was stored for
+// its PlanID.
+func (m *compactionTaskMeta) Save(task *compactionTask) error {
+	value, err := encodeCompactionTask(task)
+	if err != nil {
+		return err
+	}
+	return m.kv.Save(compactionTaskKey(task.plan.GetPlanID()), value)
+}
+
+// Drop removes a compaction task's persisted record, once it has been
+// cleaned up from memory and no longer needs to be reloaded.
+func (m *compactionTaskMeta) Drop(planID int64) error {
+	return m.kv.Remove(compactionTaskKey(planID))
+}
+
+// List reloads every persisted compaction task, e.g. at datacoord startup.
+// A record that fails to decode is skipped rather than failing the whole
+// reload, since one corrupt entry shouldn't block every other task from
+// resuming.
+func (m *compactionTaskMeta) List() ([]*compactionTask, error) {
+	_, values, err := m.kv.LoadWithPrefix(compactionTaskPrefix)
+	if err != nil {
+		return nil, err
+	}
+	tasks := make([]*compactionTask, 0, len(values))
+	for _, value := range values {
+		task, err := decodeCompactionTask(value)
+		if err != nil {
+			log.Warn("failed to decode persisted compaction task, skipping", zap.Error(err))
+			continue
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+func encodeCompactionTask(task *compactionTask) (string, error) {
+	planBytes, err := task.plan.Marshal()
+	if err != nil {
+		return "", err
+	}
+	record := persistedCompactionTask{
+		PlanID:     task.plan.GetPlanID(),
+		PlanBytes:  planBytes,
+		State:      int8(task.state),
+		DataNodeID: task.dataNodeID,
+		Priority:   int8(task.priority),
+	}
+	if task.triggerInfo != nil {
+		record.SignalID = task.triggerInfo.id
+		record.CollectionID = task.triggerInfo.collectionID
+	}
+	value, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+func decodeCompactionTask(value string) (*compactionTask, error) {
+	var record persistedCompactionTask
+	if err := json.Unmarshal([]byte(value), &record); err != nil {
+		return nil, err
+	}
+	plan := &datapb.CompactionPlan{}
+	if err := plan.Unmarshal(record.PlanBytes); err != nil {
+		return nil, err
+	}
+	return &compactionTask{
+		plan:       plan,
+		state:      compactionTaskState(record.State),
+		dataNodeID: record.DataNodeID,
+		priority:   CompactionPriority(record.Priority),
+		triggerInfo: &compactionSignal{
+			id:           record.SignalID,
+			collectionID: record.CollectionID,
+		},
+	}, nil
+}